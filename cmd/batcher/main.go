@@ -2,12 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/rtamalin/telemetry-test-tools/pkg/bgqueue"
@@ -18,17 +24,53 @@ var appStart time.Time
 
 // Options
 type Options struct {
-	Total   int
-	Batch   int
-	Prefix  string
-	Command []string
+	Total               int
+	Batch               int
+	Prefix              string
+	JobTimeout          time.Duration
+	RetryMaxAttempts    int
+	RetryInitialBackoff time.Duration
+	RetryMultiplier     float64
+	RetryMaxBackoff     time.Duration
+	RetryJitter         time.Duration
+	RetryExitCodes      string
+	UseTDigest          bool
+	JobsFile            string
+	RampUp              time.Duration
+	MetricsAddr         string
+	Output              string
+	OutputFile          string
+	Command             []string
+}
+
+// histogramBuckets are the ascending upper bounds used to render the
+// job-time histogram in the summary output.
+var histogramBuckets = []time.Duration{
+	1 * time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	1 * time.Second,
+	10 * time.Second,
 }
 
 // default option values
 var option_defaults = Options{
-	Total:  50,
-	Batch:  10,
-	Prefix: "bgjob",
+	Total:               50,
+	Batch:               10,
+	Prefix:              "bgjob",
+	JobTimeout:          0,
+	RetryMaxAttempts:    1,
+	RetryInitialBackoff: 500 * time.Millisecond,
+	RetryMultiplier:     2.0,
+	RetryMaxBackoff:     30 * time.Second,
+	RetryJitter:         250 * time.Millisecond,
+	RetryExitCodes:      "",
+	UseTDigest:          false,
+	JobsFile:            "",
+	RampUp:              0,
+	MetricsAddr:         "",
+	Output:              "text",
+	OutputFile:          "",
 	Command: []string{
 		"echo",
 		"Hello",
@@ -99,62 +141,206 @@ func runBackgroundJob(
 	resultQueue <- job
 }
 
+// parseExitCodes splits a comma-separated list of exit codes, ignoring
+// empty entries, for use as RetryPolicy.RetryableExitCodes.
+func parseExitCodes(codes string) (exitCodes []int) {
+	for _, field := range strings.Split(codes, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		code, err := strconv.Atoi(field)
+		if err != nil {
+			slog.Error("Invalid retry exit code, ignoring", slog.String("code", field))
+			continue
+		}
+		exitCodes = append(exitCodes, code)
+	}
+	return
+}
+
 func main() {
 	// define flags for our options
 	flag.IntVar(&options.Total, "total", option_defaults.Total, "The `Total` number of jobs to run")
 	flag.IntVar(&options.Batch, "batch", option_defaults.Batch, "Up to `Batch` jobs will run at the same time")
 	flag.StringVar(&options.Prefix, "prefix", option_defaults.Prefix, "The `Prefix` to use when generating the jobs name")
+	flag.DurationVar(&options.JobTimeout, "job-timeout", option_defaults.JobTimeout, "Kill a job if it runs longer than `Timeout`; 0 disables the deadline")
+	flag.IntVar(&options.RetryMaxAttempts, "retry-max-attempts", option_defaults.RetryMaxAttempts, "Retry a failed job up to `N` total attempts; <= 1 disables retries")
+	flag.DurationVar(&options.RetryInitialBackoff, "retry-initial-backoff", option_defaults.RetryInitialBackoff, "`Delay` before the second attempt")
+	flag.Float64Var(&options.RetryMultiplier, "retry-multiplier", option_defaults.RetryMultiplier, "Backoff growth `Factor` applied per retry")
+	flag.DurationVar(&options.RetryMaxBackoff, "retry-max-backoff", option_defaults.RetryMaxBackoff, "`Cap` on the computed backoff before jitter; 0 disables the cap")
+	flag.DurationVar(&options.RetryJitter, "retry-jitter", option_defaults.RetryJitter, "Upper bound of the random `Jitter` added to each backoff")
+	flag.StringVar(&options.RetryExitCodes, "retry-exit-codes", option_defaults.RetryExitCodes, "Comma-separated `Codes` whose exit status should be retried")
+	flag.BoolVar(&options.UseTDigest, "use-tdigest", option_defaults.UseTDigest, "Estimate percentiles from a t-digest sketch instead of sorting every duration")
+	flag.StringVar(&options.JobsFile, "jobs-file", option_defaults.JobsFile, "Read jobs as NDJSON (one {\"name\",\"command\",\"env\",\"cwd\"} object per line) from `Path`, instead of running Total copies of the given command")
+	flag.DurationVar(&options.RampUp, "ramp-up", option_defaults.RampUp, "Spread the start of Total jobs evenly across `Window` using the priority/schedule queue, instead of racing them all for a Batch slot at once; 0 disables ramp-up. Ignored if jobs-file is set")
+	flag.StringVar(&options.MetricsAddr, "metrics-addr", option_defaults.MetricsAddr, "Serve live /metrics (Prometheus) and /status (JSON) on `Addr` (e.g. \":9090\"); empty disables the metrics server")
+	flag.StringVar(&options.Output, "output", option_defaults.Output, "Result `Format`: text, ndjson, or csv")
+	flag.StringVar(&options.OutputFile, "output-file", option_defaults.OutputFile, "Write ndjson or csv results to `Path` instead of stdout; text output always goes to stdout")
 
 	// parse the command line
 	flag.Parse()
 
 	// validate options
-	if options.Total <= 0 {
-		slog.Error("Total must be >= 0")
-		flag.Usage()
-		os.Exit(1)
-	}
-
 	if options.Batch <= 0 {
 		slog.Error("Batch must be >= 0")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if options.Batch > options.Total {
-		slog.Error("Batch must be <= Total", slog.Int("Batch", options.Batch), slog.Int("Total", options.Total))
+	if len(options.Prefix) < 2 {
+		slog.Error("Prefix must be at least 2 characters")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if len(options.Prefix) < 2 {
-		slog.Error("Prefix must be at least 2 characters")
+	switch options.Output {
+	case "text", "ndjson", "csv":
+	default:
+		slog.Error("Output must be one of: text, ndjson, csv", slog.String("output", options.Output))
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	// use the remaining command line args as the shell command to be run
-	remainingArgs := flag.Args()
-	if len(remainingArgs) > 0 {
-		options.Command = remainingArgs
-	} else {
-		options.Command = option_defaults.Command
+	if options.JobsFile == "" {
+		if options.Total <= 0 {
+			slog.Error("Total must be >= 0")
+			flag.Usage()
+			os.Exit(1)
+		}
+
+		if options.Batch > options.Total {
+			slog.Error("Batch must be <= Total", slog.Int("Batch", options.Batch), slog.Int("Total", options.Total))
+			flag.Usage()
+			os.Exit(1)
+		}
+
+		// use the remaining command line args as the shell command to be run
+		remainingArgs := flag.Args()
+		if len(remainingArgs) > 0 {
+			options.Command = remainingArgs
+		} else {
+			options.Command = option_defaults.Command
+		}
 	}
 
-	bgQueue := bgqueue.New(
-		options.Total,
-		options.Batch,
-		"bgjob",
-		options.Command,
-	)
+	var bgQueue *bgqueue.BgQueue
+	switch {
+	case options.JobsFile != "":
+		bgQueue = bgqueue.NewForProducer(options.Batch, options.Prefix)
+	case options.RampUp > 0:
+		bgQueue = bgqueue.NewScheduled(options.Batch, options.Prefix)
+	default:
+		bgQueue = bgqueue.New(options.Total, options.Batch, options.Prefix, options.Command)
+	}
+
+	bgQueue.JobTimeout = options.JobTimeout
+	bgQueue.Stats.UseTDigest = options.UseTDigest
+
+	if options.RetryMaxAttempts > 1 {
+		bgQueue.RetryPolicy = &bgqueue.RetryPolicy{
+			MaxAttempts:        options.RetryMaxAttempts,
+			InitialBackoff:     options.RetryInitialBackoff,
+			Multiplier:         options.RetryMultiplier,
+			MaxBackoff:         options.RetryMaxBackoff,
+			Jitter:             options.RetryJitter,
+			RetryableExitCodes: parseExitCodes(options.RetryExitCodes),
+		}
+	}
+
+	// resultOutputIsStdout tracks whether a structured ResultWriter is
+	// already streaming to stdout, so the human progress banner below
+	// can be moved off of it instead of interleaving and corrupting it
+	resultOutputIsStdout := false
+
+	if options.Output != "text" {
+		var resultOutput io.Writer = os.Stdout
+		if options.OutputFile != "" {
+			outputFile, err := os.Create(options.OutputFile)
+			if err != nil {
+				slog.Error("Failed to create output file", slog.String("path", options.OutputFile), slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			defer outputFile.Close()
+			resultOutput = outputFile
+		} else {
+			resultOutputIsStdout = true
+		}
 
-	bgQueue.Run()
+		switch options.Output {
+		case "ndjson":
+			bgQueue.ResultWriter = bgqueue.NewNDJSONResultWriter(resultOutput)
+		case "csv":
+			bgQueue.ResultWriter = bgqueue.NewCSVResultWriter(resultOutput)
+		}
+	}
+
+	progressOutput := io.Writer(os.Stdout)
+	if resultOutputIsStdout {
+		progressOutput = os.Stderr
+	}
+
+	if options.MetricsAddr != "" {
+		metricsServer := bgqueue.NewMetricsServer(options.MetricsAddr, histogramBuckets)
+		if err := metricsServer.Start(); err != nil {
+			slog.Error("Failed to start metrics server", slog.String("addr", options.MetricsAddr), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer metricsServer.Stop(context.Background())
+
+		bgQueue.SetProgress(func(bq *bgqueue.BgQueue, bj *bgjob.BackgroundJob) {
+			bgqueue.ProgressTo(progressOutput)(bq, bj)
+			metricsServer.Observe(bq, bj)
+		})
+	} else if resultOutputIsStdout {
+		bgQueue.SetProgress(bgqueue.ProgressTo(progressOutput))
+	}
+
+	// cancel the queue's context on Ctrl-C or a parent shutdown signal, so
+	// in-flight jobs are killed rather than left running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch {
+	case options.JobsFile != "":
+		jobsFile, err := os.Open(options.JobsFile)
+		if err != nil {
+			slog.Error("Failed to open jobs file", slog.String("path", options.JobsFile), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer jobsFile.Close()
+
+		if err := bgQueue.RunProducer(ctx, bgqueue.NewReaderProducer(options.Prefix, jobsFile)); err != nil {
+			slog.Error("Producer stopped early", slog.String("error", err.Error()))
+		}
+	case options.RampUp > 0:
+		start := time.Now()
+		for i := 0; i < options.Total; i++ {
+			bj := bgjob.New(i, options.Prefix, options.Command)
+			if options.Total > 1 {
+				bj.Schedule = start.Add(options.RampUp * time.Duration(i) / time.Duration(options.Total-1))
+			} else {
+				bj.Schedule = start
+			}
+			bgQueue.Submit(bj)
+		}
+		bgQueue.Close()
+
+		bgQueue.RunScheduled(ctx)
+	default:
+		bgQueue.RunContext(ctx)
+	}
+
+	bgqStats := bgQueue.Stats
+
+	if options.Output != "text" {
+		return
+	}
 
 	for _, job := range bgQueue.Jobs {
 		job.Print(false)
 	}
 
-	bgqStats := bgQueue.Stats
 	slog.Debug(
 		"Times",
 		slog.Float64("Completion Rate (job/s)", bgqStats.CompletionRate()),
@@ -166,9 +352,13 @@ func main() {
 	)
 
 	fmt.Printf("Summary:\n")
-	fmt.Printf("  %-23s: %10d\n", "Total Jobs", options.Total)
+	fmt.Printf("  %-23s: %10d\n", "Total Jobs", bgqStats.Total)
 	fmt.Printf("  %-23s: %10d\n", "Batch Size", options.Batch)
 	fmt.Printf("  %-23s: %10.3f %%\n", "Success Rate", bgqStats.SuccessPercentage())
+	fmt.Printf("  %-23s: %10d\n", "Timed Out", bgqStats.TimedOut)
+	fmt.Printf("  %-23s: %10d\n", "Retried", bgqStats.Retried)
+	fmt.Printf("  %-23s: %10d\n", "Retry Successes", bgqStats.RetrySuccess)
+	fmt.Printf("  %-23s: %10d\n", "Total Attempts", bgqStats.TotalAttempts)
 	fmt.Printf("  %-23s: %10.3f jobs/s\n", "Completion Rate", bgqStats.CompletionRate())
 	fmt.Printf("  %-23s: %10.3f s\n", "Active (Wallclock) Time", bgqStats.ActiveTime.Seconds())
 	fmt.Printf("  %-23s: %10.3f s\n", "Aggregate Job Time", bgqStats.AggregateRunTime().Seconds())
@@ -178,4 +368,36 @@ func main() {
 	fmt.Printf("  %-23s: %10.3f s\n", "Job Time Variance", bgqStats.Variance().Seconds())
 	fmt.Printf("  %-23s: %10.3f s\n", "Job Time StdDev", bgqStats.StdDev().Seconds())
 	fmt.Printf("  %-23s: %10.3f s\n", "Job Time RMS", bgqStats.RootMeanSquare().Seconds())
+	fmt.Printf("  %-23s: %10.3f s\n", "P50 Job Time", bgqStats.P50().Seconds())
+	fmt.Printf("  %-23s: %10.3f s\n", "P90 Job Time", bgqStats.P90().Seconds())
+	fmt.Printf("  %-23s: %10.3f s\n", "P95 Job Time", bgqStats.P95().Seconds())
+	fmt.Printf("  %-23s: %10.3f s\n", "P99 Job Time", bgqStats.P99().Seconds())
+
+	fmt.Printf("Histogram:\n")
+	printHistogram(histogramBuckets, bgqStats.Histogram(histogramBuckets))
+}
+
+// printHistogram renders bucket counts, as returned by
+// bgstats.BgQueueStats.Histogram, as ASCII bars scaled to the largest count.
+func printHistogram(buckets []time.Duration, counts []int) {
+	maxCount := 0
+	for _, count := range counts {
+		if count > maxCount {
+			maxCount = count
+		}
+	}
+
+	const barWidth = 40
+	for i, count := range counts {
+		label := fmt.Sprintf("> %s", buckets[len(buckets)-1])
+		if i < len(buckets) {
+			label = fmt.Sprintf("<= %s", buckets[i])
+		}
+
+		barLen := 0
+		if maxCount > 0 {
+			barLen = count * barWidth / maxCount
+		}
+		fmt.Printf("  %-12s: %6d %s\n", label, count, strings.Repeat("#", barLen))
+	}
 }
@@ -2,24 +2,42 @@ package bgjob
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
 )
 
 type BackgroundJob struct {
-	Id         int           // job id
-	Name       string        // job name
-	Command    []string      // the command to execute
-	Created    time.Time     // time at which the job was created
-	Started    time.Time     // time at which the job started
-	Duration   time.Duration // how long the job took to run
-	Stdout     string        // stdout, if any, from the job
-	Stderr     string        // stderr, if any, from the job
-	ExitStatus int           // the exit status of the job itself
-	Error      error         // the error returned from the exec.Cmd.Run()
-	CmdError   error         // the error found in exec.Cmd.Err
+	Id         int             // job id
+	Name       string          // job name
+	Command    []string        // the command to execute
+	Created    time.Time       // time at which the job was created
+	Started    time.Time       // time at which the job started
+	Duration   time.Duration   // how long the job took to run
+	Stdout     string          // stdout, if any, from the job
+	Stderr     string          // stderr, if any, from the job
+	ExitStatus int             // the exit status of the job itself
+	Error      error           // the error returned from the exec.Cmd.Run()
+	CmdError   error           // the error found in exec.Cmd.Err
+	TimedOut   bool            // true if the job's context deadline expired before it finished
+	Attempts   []AttemptRecord // one entry per Run, in order, once RecordAttempt is called
+	Env        []string        // extra environment variables ("KEY=value"), appended to os.Environ(); nil to inherit only
+	Cwd        string          // working directory to run the command in; "" to inherit the current one
+	Priority   int             // higher runs first among jobs with the same Schedule; used by BgQueue's scheduled-run heap
+	Schedule   time.Time       // earliest time the job may run; zero value means eligible immediately
+}
+
+// AttemptRecord captures the outcome of a single Run of a job, so a job
+// that was retried can distinguish which attempt produced which result.
+type AttemptRecord struct {
+	Started    time.Time
+	Duration   time.Duration
+	ExitStatus int
+	Stdout     string
+	Stderr     string
 }
 
 func New(id int, prefix string, command []string) (bj *BackgroundJob) {
@@ -46,6 +64,19 @@ func (bj *BackgroundJob) MarkFinished() {
 	bj.Duration = time.Now().Sub(bj.Started)
 }
 
+// RecordAttempt appends the job's current Started/Duration/ExitStatus/
+// Stdout/Stderr to Attempts, capturing the outcome of the Run just made
+// before the caller decides whether to retry.
+func (bj *BackgroundJob) RecordAttempt() {
+	bj.Attempts = append(bj.Attempts, AttemptRecord{
+		Started:    bj.Started,
+		Duration:   bj.Duration,
+		ExitStatus: bj.ExitStatus,
+		Stdout:     bj.Stdout,
+		Stderr:     bj.Stderr,
+	})
+}
+
 func (bj *BackgroundJob) setName(prefix string) {
 	bj.Name = fmt.Sprintf("%s_%06d", prefix, bj.Id)
 }
@@ -56,12 +87,25 @@ func (bj *BackgroundJob) printBanner(subFmt string, subArgs ...any) {
 	fmt.Printf("[%s job "+subFmt+"]\n", args...)
 }
 
-func (bj *BackgroundJob) Run() (err error) {
+// Run executes the job's command under ctx, using exec.CommandContext so
+// that the child process receives SIGKILL if ctx is cancelled or its
+// deadline expires before the command finishes.
+func (bj *BackgroundJob) Run(ctx context.Context) (err error) {
+	// reset the outcome of any previous attempt, so a retried job that
+	// now succeeds doesn't keep reporting the earlier attempt's failure
+	bj.ExitStatus = 0
+	bj.CmdError = nil
+	bj.TimedOut = false
+
 	// create a Command struct to manage running the command, using
 	// Buffers for the stdout and stderr
-	cmd := exec.Command(bj.Command[0], bj.Command[1:]...)
+	cmd := exec.CommandContext(ctx, bj.Command[0], bj.Command[1:]...)
 	cmd.Stdout = new(bytes.Buffer)
 	cmd.Stderr = new(bytes.Buffer)
+	cmd.Dir = bj.Cwd
+	if bj.Env != nil {
+		cmd.Env = append(os.Environ(), bj.Env...)
+	}
 
 	// run the specified command and wait for it to complete,
 	// marking it as finished once done.
@@ -72,6 +116,12 @@ func (bj *BackgroundJob) Run() (err error) {
 	bj.Stdout = cmd.Stdout.(*bytes.Buffer).String()
 	bj.Stderr = cmd.Stderr.(*bytes.Buffer).String()
 
+	// a deadline-exceeded ctx error means the process was killed because
+	// it overran its timeout, rather than failing on its own
+	if ctx.Err() == context.DeadlineExceeded {
+		bj.TimedOut = true
+	}
+
 	// extract the exit status if the job command ran but failed
 	if bj.Error != nil {
 		// extract the exit status if the the command ran but failed.
@@ -134,6 +184,18 @@ func (bj *BackgroundJob) Print(detailed bool) {
 		}
 	}
 
+	if bj.TimedOut {
+		bj.printBanner("timed out")
+	}
+
+	if attempts := len(bj.Attempts); attempts > 1 {
+		if bj.ExitStatus == 0 {
+			bj.printBanner("succeeded on attempt %d/%d", attempts, attempts)
+		} else {
+			bj.printBanner("failed after %d/%d attempts", attempts, attempts)
+		}
+	}
+
 	bj.printBanner(
 		"exit status: %3d, times: %+13.6fs (%13.6fs)",
 		bj.ExitStatus,
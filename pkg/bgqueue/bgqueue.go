@@ -1,9 +1,13 @@
 package bgqueue
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"math"
+	"math/rand"
+	"os"
 	"sync"
 	"time"
 
@@ -23,18 +27,68 @@ type BgQueue struct {
 	jobSlots   chan bgJobSlot
 	jobResults chan *bgjob.BackgroundJob
 
+	// statsMu guards bq.Jobs and bq.Stats.Total against concurrent growth
+	// (RunProducer's producer goroutine, Submit) racing the draining
+	// goroutine's reads of bq.Stats (directly, or via a ProgressCallback)
+	statsMu sync.Mutex
+
 	// progress tracking
-	progress ProgressCallback
-	numTicks int
-	nextTick int
-	tickStep int
+	progress            ProgressCallback
+	progressEveryResult bool // set by SetProgress; bypasses the tick gating below
+	numTicks            int
+	nextTick            int
+	tickStep            int
+
+	// scheduling (RunScheduled only)
+	heap   jobHeap
+	heapMu sync.Mutex
+	closed bool
+	wake   chan struct{}
 
 	// public attributes
-	Command    []string
-	JobGroup   *sync.WaitGroup
-	Jobs       []*bgjob.BackgroundJob
-	QueueStart time.Time
-	Stats      *bgstats.BgQueueStats
+	Command      []string
+	JobGroup     *sync.WaitGroup
+	Jobs         []*bgjob.BackgroundJob
+	QueueStart   time.Time
+	Stats        *bgstats.BgQueueStats
+	JobTimeout   time.Duration // per-job deadline; 0 means no deadline
+	RetryPolicy  *RetryPolicy  // retry behaviour for failed jobs; nil disables retries
+	ResultWriter ResultWriter  // receives each job result and the final summary; nil disables it
+}
+
+// RetryPolicy configures whether and how a failed job is retried.
+type RetryPolicy struct {
+	MaxAttempts        int           // total attempts, including the first; <= 1 disables retries
+	InitialBackoff     time.Duration // delay before the second attempt
+	Multiplier         float64       // backoff growth factor applied per subsequent retry
+	MaxBackoff         time.Duration // cap on the computed backoff, applied before jitter; 0 means no cap
+	Jitter             time.Duration // upper bound of the random jitter added to each backoff
+	RetryableExitCodes []int         // job ExitStatus values that should be retried
+}
+
+func (rp *RetryPolicy) isRetryable(exitStatus int) bool {
+	for _, code := range rp.RetryableExitCodes {
+		if code == exitStatus {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay to wait before making attempt+1, given that
+// `attempt` attempts have already been made: delay = min(Initial *
+// Multiplier^(attempt-1), MaxBackoff) + rand(Jitter).
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := float64(rp.InitialBackoff) * math.Pow(rp.Multiplier, float64(attempt-1))
+	if rp.MaxBackoff > 0 && delay > float64(rp.MaxBackoff) {
+		delay = float64(rp.MaxBackoff)
+	}
+
+	d := time.Duration(delay)
+	if rp.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(rp.Jitter)))
+	}
+	return d
 }
 
 func New(totalJobs, jobSlots int, prefix string, command []string) (bq *BgQueue) {
@@ -43,6 +97,24 @@ func New(totalJobs, jobSlots int, prefix string, command []string) (bq *BgQueue)
 	return
 }
 
+// NewForProducer creates a BgQueue to be driven by RunProducer. Unlike
+// New, it does not pre-allocate a fixed job list up front, since the
+// number of jobs is only known as the producer yields them.
+func NewForProducer(jobSlots int, prefix string) (bq *BgQueue) {
+	bq = new(BgQueue)
+	bq.numSlots = jobSlots
+	bq.prefix = prefix
+
+	bq.progress = Progress
+
+	bq.Stats = bgstats.New(0)
+
+	bq.jobSlots = make(chan bgJobSlot, jobSlots)
+	bq.JobGroup = new(sync.WaitGroup)
+
+	return
+}
+
 func (bq *BgQueue) updateNextTick() {
 	bq.nextTick = min(bq.nextTick+bq.tickStep, bq.numJobs)
 }
@@ -84,27 +156,29 @@ func (bq *BgQueue) Init(totalJobs, jobSlots int, prefix string, command []string
 	}
 }
 
-func (bq *BgQueue) runJob(
-	bj *bgjob.BackgroundJob,
-	slot chan bgJobSlot, // semaphore to limit concurrency
-	resultQueue chan<- *bgjob.BackgroundJob, // Queue (channel) where results are sent
-) {
-	// ensure WaitGroup is signalled when routine finishes
-	defer bq.JobGroup.Done()
-
-	// job is ready to run
-	bj.MarkReady()
-
-	// acquire a job slot from the semaphore and ensure we release it when
-	// the routine finishes
-	slot <- bgJobSlot{}
-	defer func() { <-slot }()
-
+// runAttempt runs a single attempt of bj under ctx, applying the per-job
+// JobTimeout on top of ctx if configured, and recording the outcome as
+// the job's latest AttemptRecord.
+func (bq *BgQueue) runAttempt(ctx context.Context, bj *bgjob.BackgroundJob, attempt int) error {
 	// job has now started
 	bj.MarkStarted()
 
+	// apply the per-job deadline, if configured, on top of the queue's ctx
+	jobCtx := ctx
+	var cancel context.CancelFunc
+	if bq.JobTimeout > 0 {
+		jobCtx, cancel = context.WithTimeout(ctx, bq.JobTimeout)
+	}
+
 	// run the command
-	if err := bj.Run(); err != nil {
+	err := bj.Run(jobCtx)
+	if cancel != nil {
+		cancel()
+	}
+
+	bj.RecordAttempt()
+
+	if err != nil {
 		var cmdError string
 		if bj.CmdError != nil {
 			cmdError = bj.CmdError.Error()
@@ -114,6 +188,7 @@ func (bq *BgQueue) runJob(
 			slog.Int("id", bj.Id),
 			slog.String("name", bj.Name),
 			slog.Any("command", bj.Command),
+			slog.Int("attempt", attempt),
 			slog.Int("exitStatus", bj.ExitStatus),
 			slog.String("cmdError", cmdError),
 			slog.String("jobError", bj.Error.Error()),
@@ -121,11 +196,81 @@ func (bq *BgQueue) runJob(
 		)
 	}
 
+	return err
+}
+
+// retryable reports whether bj should be attempted again, given that err
+// was returned by its most recent attempt.
+func (bq *BgQueue) retryable(err error, bj *bgjob.BackgroundJob, attempt int) bool {
+	return err != nil && bq.RetryPolicy != nil &&
+		attempt < bq.RetryPolicy.MaxAttempts &&
+		bq.RetryPolicy.isRetryable(bj.ExitStatus)
+}
+
+func (bq *BgQueue) runJob(
+	ctx context.Context,
+	bj *bgjob.BackgroundJob,
+	slot chan bgJobSlot, // semaphore to limit concurrency
+	resultQueue chan<- *bgjob.BackgroundJob, // Queue (channel) where results are sent
+) {
+	// ensure WaitGroup is signalled when routine finishes
+	defer bq.JobGroup.Done()
+
+	// job is ready to run
+	bj.MarkReady()
+
+	for attempt := 1; ; attempt++ {
+		// acquire a job slot from the semaphore, releasing it again once
+		// this attempt finishes so other jobs can use it while we back off
+		slot <- bgJobSlot{}
+		err := bq.runAttempt(ctx, bj, attempt)
+		<-slot
+
+		if !bq.retryable(err, bj, attempt) {
+			break
+		}
+
+		// re-enqueue the job for another attempt after backing off
+		time.Sleep(bq.RetryPolicy.backoff(attempt))
+	}
+
 	// complete this routine, sending job result back to the main goroutine
 	resultQueue <- bj
 }
 
+// recordResult updates stats for a completed job and forwards it to
+// ResultWriter, if one is set.
+func (bq *BgQueue) recordResult(bj *bgjob.BackgroundJob) {
+	bq.Stats.Update(bj)
+
+	if bq.ResultWriter != nil {
+		if err := bq.ResultWriter.WriteResult(bj); err != nil {
+			slog.Warn("ResultWriter failed to write job result", slog.String("name", bj.Name), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// finish marks the stats as finished and forwards the final summary to
+// ResultWriter, if one is set.
+func (bq *BgQueue) finish() {
+	bq.Stats.Finish()
+
+	if bq.ResultWriter != nil {
+		if err := bq.ResultWriter.WriteSummary(bq.Stats); err != nil {
+			slog.Warn("ResultWriter failed to write summary", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// Run runs the queue to completion with no parent context, equivalent to
+// RunContext(context.Background()).
 func (bq *BgQueue) Run() {
+	bq.RunContext(context.Background())
+}
+
+// RunContext runs the queue to completion, cancelling any still-running
+// jobs (and stopping any not yet started) if ctx is cancelled.
+func (bq *BgQueue) RunContext(ctx context.Context) {
 	bq.Stats.Start()
 	bq.QueueStart = time.Now()
 
@@ -136,7 +281,7 @@ func (bq *BgQueue) Run() {
 		bq.JobGroup.Add(1)
 
 		// create a go routine to run the background job
-		go bq.runJob(bq.Jobs[i], bq.jobSlots, bq.jobResults)
+		go bq.runJob(ctx, bq.Jobs[i], bq.jobSlots, bq.jobResults)
 	}
 
 	// wait for background jobs to complete
@@ -146,9 +291,11 @@ func (bq *BgQueue) Run() {
 	}()
 
 	for bj := range bq.jobResults {
-		bq.Stats.Update(bj)
-		if bq.Stats.Completed >= bq.nextTick {
-			bq.updateNextTick()
+		bq.recordResult(bj)
+		if bq.progressEveryResult || bq.Stats.Completed >= bq.nextTick {
+			if !bq.progressEveryResult {
+				bq.updateNextTick()
+			}
 			if bq.progress != nil {
 				bq.progress(bq, bj)
 			}
@@ -162,20 +309,112 @@ func (bq *BgQueue) Run() {
 			)
 		}
 	}
-	bq.Stats.Finish()
+	bq.finish()
+}
+
+// RunProducer runs the queue against jobs pulled from producer on demand,
+// dispatching each onto the semaphore as slots free up, instead of the
+// fixed pre-allocated job list created by New. The number of jobs need
+// not be known up front: producer may be backed by a file of command
+// lines, a channel, or a database/Redis-backed source. Jobs are appended
+// to bq.Jobs and bq.Stats.Total grows as they are produced. It returns
+// the first error returned by producer.Next, if any; jobs already
+// dispatched are still run to completion.
+func (bq *BgQueue) RunProducer(ctx context.Context, producer JobProducer) error {
+	bq.Stats.Start()
+	bq.QueueStart = time.Now()
+
+	bq.jobResults = make(chan *bgjob.BackgroundJob, bq.numSlots)
+
+	var produceErr error
+
+	go func() {
+		defer func() {
+			bq.JobGroup.Wait()
+			close(bq.jobResults)
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			// wait for a free slot before pulling the next job, so the
+			// producer is only asked for a job the queue can start now
+			select {
+			case bq.jobSlots <- bgJobSlot{}:
+			case <-ctx.Done():
+				return
+			}
+
+			bj, ok, err := producer.Next(ctx)
+			if err != nil || !ok {
+				<-bq.jobSlots
+				produceErr = err
+				return
+			}
+
+			bq.statsMu.Lock()
+			bq.Jobs = append(bq.Jobs, bj)
+			bq.Stats.Total++
+			bq.statsMu.Unlock()
+
+			// the slot for the first attempt is already held
+			bq.JobGroup.Add(1)
+			go bq.runScheduledJob(ctx, bj)
+		}
+	}()
+
+	for bj := range bq.jobResults {
+		bq.recordResult(bj)
+
+		bq.statsMu.Lock()
+		if bq.progress != nil {
+			bq.progress(bq, bj)
+		}
+		bq.statsMu.Unlock()
+	}
+	bq.finish()
+
+	return produceErr
 }
 
 // progress callback type
 type ProgressCallback func(bq *BgQueue, bj *bgjob.BackgroundJob)
 
+// SetProgress overrides the queue's progress callback, which defaults to
+// Progress (printing a one-line completion summary at roughly 1/20th of
+// the run's job count under RunContext). Unlike that default, an
+// overridden callback fires on every completed job under RunContext too
+// - not just at the default's print cadence - so consumers like
+// MetricsServer observe every result rather than going stale between
+// ticks. Pass nil to disable progress reporting.
+func (bq *BgQueue) SetProgress(cb ProgressCallback) {
+	bq.progress = cb
+	bq.progressEveryResult = cb != nil
+}
+
 func Progress(bq *BgQueue, bj *bgjob.BackgroundJob) {
-	fmt.Printf(
-		"Progress: complete=%6d(%6.2f%%) fail=%6d(%6.2f%%) success=%6d(%6.2f%%)\n",
-		bq.Stats.Completed,
-		bq.Stats.CompletionPercentage(),
-		bq.Stats.Failed,
-		bq.Stats.FailurePercentage(),
-		bq.Stats.Succeeded,
-		bq.Stats.SuccessPercentage(),
-	)
+	ProgressTo(os.Stdout)(bq, bj)
+}
+
+// ProgressTo returns a ProgressCallback equivalent to Progress, but
+// writing to w instead of unconditionally to os.Stdout - for callers
+// whose stdout is already spoken for, e.g. by a ResultWriter streaming
+// structured output that a human banner line would otherwise corrupt.
+func ProgressTo(w io.Writer) ProgressCallback {
+	return func(bq *BgQueue, bj *bgjob.BackgroundJob) {
+		fmt.Fprintf(
+			w,
+			"Progress: complete=%6d(%6.2f%%) fail=%6d(%6.2f%%) success=%6d(%6.2f%%)\n",
+			bq.Stats.Completed,
+			bq.Stats.CompletionPercentage(),
+			bq.Stats.Failed,
+			bq.Stats.FailurePercentage(),
+			bq.Stats.Succeeded,
+			bq.Stats.SuccessPercentage(),
+		)
+	}
 }
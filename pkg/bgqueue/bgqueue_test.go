@@ -0,0 +1,65 @@
+package bgqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoffExponentialGrowth(t *testing.T) {
+	rp := &RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+	}
+	for i, w := range want {
+		attempt := i + 1
+		if got := rp.backoff(attempt); got != w {
+			t.Errorf("backoff(%d) = %s, want %s", attempt, got, w)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffMaxBackoffCap(t *testing.T) {
+	rp := &RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		Multiplier:     10,
+		MaxBackoff:     1 * time.Second,
+	}
+
+	// attempt 3 would be 100ms * 10^2 = 10s uncapped; MaxBackoff must win
+	if got := rp.backoff(3); got != 1*time.Second {
+		t.Errorf("backoff(3) = %s, want capped %s", got, 1*time.Second)
+	}
+}
+
+func TestRetryPolicyBackoffJitterBounds(t *testing.T) {
+	rp := &RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		Multiplier:     1,
+		Jitter:         50 * time.Millisecond,
+	}
+
+	for i := 0; i < 100; i++ {
+		d := rp.backoff(1)
+		if d < 100*time.Millisecond || d >= 150*time.Millisecond {
+			t.Fatalf("backoff(1) = %s, want in [100ms, 150ms)", d)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffNoJitterIsDeterministic(t *testing.T) {
+	rp := &RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	if got, want := rp.backoff(2), 200*time.Millisecond; got != want {
+		t.Errorf("backoff(2) = %s, want %s", got, want)
+	}
+}
@@ -2,26 +2,40 @@ package bgstats
 
 import (
 	"math"
+	"sort"
 	"time"
 
 	"github.com/rtamalin/telemetry-test-tools/pkg/bgqueue/bgjob"
 	"golang.org/x/exp/constraints"
 )
 
+// defaultTDigestCompression bounds how many centroids the t-digest used by
+// UseTDigest keeps; higher values trade memory for accuracy.
+const defaultTDigestCompression = 100
+
 // Background Queue Stats
 type BgQueueStats struct {
 	// private attributes
 	started   time.Time
 	finished  time.Time
 	durations []time.Duration
+	digest    *tdigest
 
 	// public attributes
-	Total      int
-	Completed  int
-	Succeeded  int
-	Failed     int
-	Invalid    int
-	ActiveTime time.Duration
+	Total         int
+	Completed     int
+	Succeeded     int
+	Failed        int
+	Invalid       int
+	TimedOut      int
+	Retried       int
+	RetrySuccess  int
+	TotalAttempts int
+	ActiveTime    time.Duration
+
+	// UseTDigest, when true, makes Percentile estimate from a t-digest
+	// sketch of completed durations instead of sorting the full history.
+	UseTDigest bool
 }
 
 func New(totalJobs int) (bqs *BgQueueStats) {
@@ -32,6 +46,7 @@ func New(totalJobs int) (bqs *BgQueueStats) {
 
 func (bqs *BgQueueStats) Init(totalJobs int) {
 	bqs.Total = totalJobs
+	bqs.digest = newTDigest(defaultTDigestCompression)
 }
 
 func (bqs *BgQueueStats) Start() {
@@ -54,7 +69,23 @@ func (bqs *BgQueueStats) Update(bj *bgjob.BackgroundJob) {
 		bqs.Invalid += 1
 	}
 
+	// TimedOut is tracked separately from the above, since a timed-out job
+	// is still reported as Failed or Invalid based on its exit status
+	if bj.TimedOut {
+		bqs.TimedOut += 1
+	}
+
+	// a job with more than one recorded attempt was retried at least once
+	bqs.TotalAttempts += len(bj.Attempts)
+	if len(bj.Attempts) > 1 {
+		bqs.Retried += 1
+		if bj.ExitStatus == 0 {
+			bqs.RetrySuccess += 1
+		}
+	}
+
 	bqs.durations = append(bqs.durations, bj.Duration)
+	bqs.digest.add(bj.Duration.Seconds())
 }
 
 func percentage(fraction, total float64) (pct float64) {
@@ -79,6 +110,56 @@ func (bqs *BgQueueStats) InvalidPercentage() float64 {
 	return percentage(float64(bqs.Failed), float64(bqs.Total))
 }
 
+func (bqs *BgQueueStats) TimedOutPercentage() float64 {
+	return percentage(float64(bqs.TimedOut), float64(bqs.Total))
+}
+
+// Percentile returns the pth percentile (0 <= p <= 100) of job durations.
+// By default it sorts a copy of the raw durations and linearly
+// interpolates between ranks (rank = p*(n-1)/100, lower = floor(rank),
+// upper = ceil(rank)). When UseTDigest is set it instead estimates the
+// percentile from the t-digest sketch, trading some accuracy for a
+// lookup that does not sort the full history. Update still keeps every
+// duration either way (the other statistics and Histogram read them), so
+// UseTDigest does not bound the memory used by BgQueueStats.
+func (bqs *BgQueueStats) Percentile(p float64) time.Duration {
+	if bqs.UseTDigest {
+		return fromSecond(bqs.digest.quantile(p / 100))
+	}
+
+	n := len(bqs.durations)
+	if n == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration(nil), bqs.durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := (p / 100) * float64(n-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + time.Duration((rank-float64(lower))*float64(sorted[upper]-sorted[lower]))
+}
+
+func (bqs *BgQueueStats) P50() time.Duration { return bqs.Percentile(50) }
+func (bqs *BgQueueStats) P90() time.Duration { return bqs.Percentile(90) }
+func (bqs *BgQueueStats) P95() time.Duration { return bqs.Percentile(95) }
+func (bqs *BgQueueStats) P99() time.Duration { return bqs.Percentile(99) }
+
+// Histogram buckets job durations by the given ascending upper bounds and
+// returns the count falling into each one; the final element of the
+// result counts everything above the last bound, so it has one more
+// entry than buckets. Suitable for rendering as an ASCII bar chart.
+func (bqs *BgQueueStats) Histogram(buckets []time.Duration) []int {
+	counts := make([]int, len(buckets)+1)
+	for _, d := range bqs.durations {
+		i := sort.Search(len(buckets), func(i int) bool { return buckets[i] >= d })
+		counts[i]++
+	}
+	return counts
+}
+
 func (bqs *BgQueueStats) CompletionRate() float64 {
 	return 1.0 / (bqs.ActiveTime.Seconds() / float64(bqs.Completed))
 }
@@ -120,6 +201,10 @@ func sum[T numeric](vals []T) T {
 }
 
 func max[T numeric](vals []T) T {
+	if len(vals) == 0 {
+		return 0
+	}
+
 	m := vals[0]
 	for _, v := range vals[1:] {
 		if v > m {
@@ -130,6 +215,10 @@ func max[T numeric](vals []T) T {
 }
 
 func min[T numeric](vals []T) T {
+	if len(vals) == 0 {
+		return 0
+	}
+
 	m := vals[0]
 	for _, v := range vals[1:] {
 		if v < m {
@@ -139,7 +228,12 @@ func min[T numeric](vals []T) T {
 	return m
 }
 
+// average returns 0 for an empty slice, since RunProducer/RunScheduled
+// make "zero jobs seen" a normal outcome rather than a programmer error.
 func average[T numeric](vals []T) T {
+	if len(vals) == 0 {
+		return 0
+	}
 	return sum(vals) / T(len(vals))
 }
 
@@ -190,3 +284,95 @@ func (bqs *BgQueueStats) Variance() time.Duration {
 
 	return average(squaredDeltas)
 }
+
+// tdigest is a simplified streaming quantile sketch, based on Ted
+// Dunning's t-digest: instead of keeping every sample it merges values
+// into a bounded number of weighted centroids, so Percentile stays O(1)
+// and memory stays O(compression) rather than O(n) on million-job runs.
+type tdigest struct {
+	compression float64
+	centroids   []tdigestCentroid // kept sorted by mean
+	count       float64
+}
+
+type tdigestCentroid struct {
+	mean   float64
+	weight float64
+}
+
+func newTDigest(compression float64) *tdigest {
+	return &tdigest{compression: compression}
+}
+
+// add merges x in as a new singleton centroid, compressing once the
+// centroid count has grown well past the target compression factor.
+func (td *tdigest) add(x float64) {
+	i := sort.Search(len(td.centroids), func(i int) bool { return td.centroids[i].mean >= x })
+	td.centroids = append(td.centroids, tdigestCentroid{})
+	copy(td.centroids[i+1:], td.centroids[i:])
+	td.centroids[i] = tdigestCentroid{mean: x, weight: 1}
+	td.count++
+
+	if float64(len(td.centroids)) > 20*td.compression {
+		td.compress()
+	}
+}
+
+// compress merges adjacent centroids, respecting the standard t-digest
+// size bound k(q) = 4*n*q*(1-q)/compression on each merged centroid's
+// weight, so precision is highest near the tails and coarsest at the
+// median.
+func (td *tdigest) compress() {
+	if len(td.centroids) == 0 {
+		return
+	}
+
+	merged := make([]tdigestCentroid, 0, len(td.centroids))
+	cur := td.centroids[0]
+	cumWeight := 0.0
+
+	for _, c := range td.centroids[1:] {
+		q := (cumWeight + cur.weight/2) / td.count
+		maxWeight := 4 * td.count * q * (1 - q) / td.compression
+
+		if cur.weight+c.weight <= maxWeight {
+			totalWeight := cur.weight + c.weight
+			cur.mean = (cur.mean*cur.weight + c.mean*c.weight) / totalWeight
+			cur.weight = totalWeight
+		} else {
+			cumWeight += cur.weight
+			merged = append(merged, cur)
+			cur = c
+		}
+	}
+	td.centroids = append(merged, cur)
+}
+
+// quantile estimates the value at quantile q (0 <= q <= 1) by linearly
+// interpolating across the cumulative weight of the centroids.
+func (td *tdigest) quantile(q float64) float64 {
+	switch len(td.centroids) {
+	case 0:
+		return 0
+	case 1:
+		return td.centroids[0].mean
+	}
+
+	target := q * td.count
+	cumWeight := 0.0
+
+	for i, c := range td.centroids {
+		next := cumWeight + c.weight
+		if i == 0 && target <= next {
+			return c.mean
+		}
+		if target <= next || i == len(td.centroids)-1 {
+			prev := td.centroids[i-1]
+			frac := (target - cumWeight) / c.weight
+			return prev.mean + frac*(c.mean-prev.mean)
+		}
+		cumWeight = next
+	}
+
+	return td.centroids[len(td.centroids)-1].mean
+}
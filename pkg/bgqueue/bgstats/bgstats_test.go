@@ -0,0 +1,78 @@
+package bgstats
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rtamalin/telemetry-test-tools/pkg/bgqueue/bgjob"
+)
+
+func populated(t *testing.T, useTDigest bool) *BgQueueStats {
+	t.Helper()
+
+	durations := []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		30 * time.Millisecond,
+		40 * time.Millisecond,
+		50 * time.Millisecond,
+		60 * time.Millisecond,
+		70 * time.Millisecond,
+		80 * time.Millisecond,
+		90 * time.Millisecond,
+		100 * time.Millisecond,
+	}
+
+	bqs := New(len(durations))
+	bqs.UseTDigest = useTDigest
+	for i, d := range durations {
+		bj := bgjob.New(i, "job", []string{"true"})
+		bj.Duration = d
+		bqs.Update(bj)
+	}
+	return bqs
+}
+
+// TestPercentileSortedVsTDigestAgreement checks that the t-digest estimate
+// tracks the exact sorted-rank percentile within a reasonable tolerance,
+// since callers choose UseTDigest purely as a memory/accuracy trade-off,
+// not for a different answer.
+func TestPercentileSortedVsTDigestAgreement(t *testing.T) {
+	sorted := populated(t, false)
+	digest := populated(t, true)
+
+	const tolerance = 10 * time.Millisecond
+	for _, p := range []float64{50, 90, 95, 99} {
+		want := sorted.Percentile(p)
+		got := digest.Percentile(p)
+
+		diff := want - got
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > tolerance {
+			t.Errorf("Percentile(%v): sorted=%s tdigest=%s, diff %s exceeds tolerance %s", p, want, got, diff, tolerance)
+		}
+	}
+}
+
+func TestPercentileEmptyStats(t *testing.T) {
+	for _, useTDigest := range []bool{false, true} {
+		bqs := New(0)
+		bqs.UseTDigest = useTDigest
+		if got := bqs.Percentile(50); got != 0 {
+			t.Errorf("Percentile(50) on empty stats (UseTDigest=%v) = %s, want 0", useTDigest, got)
+		}
+	}
+}
+
+func TestPercentileSortedExactRank(t *testing.T) {
+	bqs := populated(t, false)
+
+	// with 10 evenly spaced samples 10ms..100ms, P50 should land exactly
+	// on the interpolated midpoint between the 5th and 6th samples
+	if got, want := bqs.Percentile(50), time.Duration(math.Round(55*float64(time.Millisecond))); got != want {
+		t.Errorf("Percentile(50) = %s, want %s", got, want)
+	}
+}
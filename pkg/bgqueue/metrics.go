@@ -0,0 +1,190 @@
+package bgqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rtamalin/telemetry-test-tools/pkg/bgqueue/bgjob"
+)
+
+// metricsSnapshot is the most recently observed queue state, guarded by
+// MetricsServer.mu so HTTP handlers never read bq or bq.Stats directly
+// from a goroutine other than the one driving the queue.
+type metricsSnapshot struct {
+	total          int
+	completed      int
+	succeeded      int
+	failed         int
+	invalid        int
+	activeSlots    int
+	completionRate float64
+	histogram      []int
+}
+
+// MetricsServer exposes a running BgQueue's progress over HTTP: /metrics
+// in Prometheus text exposition format, and /status as JSON. It is fed
+// by Observe, which matches ProgressCallback, so it can be wired onto a
+// queue's existing progress hook instead of polling the queue itself.
+type MetricsServer struct {
+	buckets []time.Duration
+	srv     *http.Server
+
+	mu   sync.RWMutex
+	snap metricsSnapshot
+}
+
+// NewMetricsServer returns a MetricsServer listening on addr (e.g.
+// ":9090") once Start is called, reporting the job-duration histogram
+// using buckets.
+func NewMetricsServer(addr string, buckets []time.Duration) *MetricsServer {
+	ms := &MetricsServer{buckets: buckets}
+	ms.snap.histogram = make([]int, len(buckets)+1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", ms.handleMetrics)
+	mux.HandleFunc("/status", ms.handleStatus)
+	ms.srv = &http.Server{Addr: addr, Handler: mux}
+
+	return ms
+}
+
+// Start binds the server's listener and begins serving in the
+// background, returning once the listener is ready (or failed to bind).
+func (ms *MetricsServer) Start() error {
+	ln, err := net.Listen("tcp", ms.srv.Addr)
+	if err != nil {
+		return fmt.Errorf("bgqueue: starting metrics server: %w", err)
+	}
+
+	go func() {
+		if err := ms.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("metrics server stopped: %s\n", err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop gracefully shuts the metrics server down.
+func (ms *MetricsServer) Stop(ctx context.Context) error {
+	return ms.srv.Shutdown(ctx)
+}
+
+// Observe implements ProgressCallback: it snapshots bq's current stats
+// and slot usage so /metrics and /status can serve them without racing
+// the queue's own goroutines. It relies on being called, like any
+// progress callback, only from the goroutine that drains bq's job
+// results and updates bq.Stats. Wire it via BgQueue.SetProgress rather
+// than assigning bq's progress field directly: SetProgress also makes
+// the queue invoke the callback on every completed job, including under
+// RunContext, whose default callback only fires at its own print
+// cadence - without that, /metrics and /status would go stale between
+// ticks on a long RunContext batch.
+func (ms *MetricsServer) Observe(bq *BgQueue, bj *bgjob.BackgroundJob) {
+	var rate float64
+	if elapsed := time.Since(bq.QueueStart).Seconds(); elapsed > 0 {
+		rate = float64(bq.Stats.Completed) / elapsed
+	}
+
+	snap := metricsSnapshot{
+		total:          bq.Stats.Total,
+		completed:      bq.Stats.Completed,
+		succeeded:      bq.Stats.Succeeded,
+		failed:         bq.Stats.Failed,
+		invalid:        bq.Stats.Invalid,
+		activeSlots:    len(bq.jobSlots),
+		completionRate: rate,
+		histogram:      bq.Stats.Histogram(ms.buckets),
+	}
+
+	ms.mu.Lock()
+	ms.snap = snap
+	ms.mu.Unlock()
+}
+
+func (ms *MetricsServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	ms.mu.RLock()
+	snap := ms.snap
+	ms.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP bgqueue_jobs_total Count of jobs reaching each terminal state.")
+	fmt.Fprintln(w, "# TYPE bgqueue_jobs_total counter")
+	fmt.Fprintf(w, "bgqueue_jobs_total{state=\"completed\"} %d\n", snap.completed)
+	fmt.Fprintf(w, "bgqueue_jobs_total{state=\"succeeded\"} %d\n", snap.succeeded)
+	fmt.Fprintf(w, "bgqueue_jobs_total{state=\"failed\"} %d\n", snap.failed)
+	fmt.Fprintf(w, "bgqueue_jobs_total{state=\"invalid\"} %d\n", snap.invalid)
+
+	fmt.Fprintln(w, "# HELP bgqueue_job_duration_seconds Histogram of completed job durations.")
+	fmt.Fprintln(w, "# TYPE bgqueue_job_duration_seconds histogram")
+	cumulative := 0
+	for i, bucket := range ms.buckets {
+		cumulative += snap.histogram[i]
+		fmt.Fprintf(w, "bgqueue_job_duration_seconds_bucket{le=\"%s\"} %d\n", formatSeconds(bucket), cumulative)
+	}
+	cumulative += snap.histogram[len(ms.buckets)]
+	fmt.Fprintf(w, "bgqueue_job_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "bgqueue_job_duration_seconds_count %d\n", cumulative)
+
+	fmt.Fprintln(w, "# HELP bgqueue_active_slots Number of job slots currently in use.")
+	fmt.Fprintln(w, "# TYPE bgqueue_active_slots gauge")
+	fmt.Fprintf(w, "bgqueue_active_slots %d\n", snap.activeSlots)
+
+	fmt.Fprintln(w, "# HELP bgqueue_completion_rate Jobs completed per second since the queue started.")
+	fmt.Fprintln(w, "# TYPE bgqueue_completion_rate gauge")
+	fmt.Fprintf(w, "bgqueue_completion_rate %s\n", strconv.FormatFloat(snap.completionRate, 'f', -1, 64))
+}
+
+// formatSeconds renders d the way Prometheus bucket labels conventionally
+// render seconds: a plain decimal, not Go's "1ms"/"1s" duration syntax.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// statusBucket is one histogram bucket in the /status JSON response.
+type statusBucket struct {
+	LE    string `json:"le"`
+	Count int    `json:"count"`
+}
+
+// statusResponse is the /status JSON response body.
+type statusResponse struct {
+	Total          int            `json:"total"`
+	Completed      int            `json:"completed"`
+	Succeeded      int            `json:"succeeded"`
+	Failed         int            `json:"failed"`
+	Invalid        int            `json:"invalid"`
+	ActiveSlots    int            `json:"active_slots"`
+	CompletionRate float64        `json:"completion_rate"`
+	Histogram      []statusBucket `json:"histogram"`
+}
+
+func (ms *MetricsServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	ms.mu.RLock()
+	snap := ms.snap
+	ms.mu.RUnlock()
+
+	resp := statusResponse{
+		Total:          snap.total,
+		Completed:      snap.completed,
+		Succeeded:      snap.succeeded,
+		Failed:         snap.failed,
+		Invalid:        snap.invalid,
+		ActiveSlots:    snap.activeSlots,
+		CompletionRate: snap.completionRate,
+	}
+	for i, bucket := range ms.buckets {
+		resp.Histogram = append(resp.Histogram, statusBucket{LE: formatSeconds(bucket), Count: snap.histogram[i]})
+	}
+	resp.Histogram = append(resp.Histogram, statusBucket{LE: "+Inf", Count: snap.histogram[len(ms.buckets)]})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,148 @@
+package bgqueue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rtamalin/telemetry-test-tools/pkg/bgqueue/bgjob"
+)
+
+// JobProducer supplies BackgroundJobs to a queue on demand, so RunProducer
+// can pull work from a file, channel, or external source instead of the
+// queue pre-allocating a fixed number of identical jobs up front. Next
+// returns the next job, or ok=false once the producer is exhausted; it
+// returns a non-nil error if the next job could not be produced (e.g.
+// malformed input), which stops RunProducer without affecting jobs
+// already in flight.
+type JobProducer interface {
+	Next(ctx context.Context) (job *bgjob.BackgroundJob, ok bool, err error)
+}
+
+// Command describes a single job to run: its name prefix (the job id is
+// appended to it, and the producer's prefix is used when it is empty),
+// the command line to execute, and optionally the environment and
+// working directory to run it in.
+type Command struct {
+	Name    string
+	Command []string
+	Env     []string
+	Cwd     string
+}
+
+func newJobFromCommand(id int, prefix string, cmd Command) *bgjob.BackgroundJob {
+	name := cmd.Name
+	if name == "" {
+		name = prefix
+	}
+
+	bj := bgjob.New(id, name, cmd.Command)
+	bj.Env = cmd.Env
+	bj.Cwd = cmd.Cwd
+	return bj
+}
+
+// sliceProducer yields one job per Command in a pre-built slice.
+type sliceProducer struct {
+	prefix   string
+	commands []Command
+	next     int
+}
+
+// NewSliceProducer returns a JobProducer that yields one job per Command
+// in commands, in order.
+func NewSliceProducer(prefix string, commands []Command) JobProducer {
+	return &sliceProducer{prefix: prefix, commands: commands}
+}
+
+func (p *sliceProducer) Next(ctx context.Context) (*bgjob.BackgroundJob, bool, error) {
+	if p.next >= len(p.commands) {
+		return nil, false, nil
+	}
+
+	bj := newJobFromCommand(p.next, p.prefix, p.commands[p.next])
+	p.next++
+	return bj, true, nil
+}
+
+// repeatProducer yields n copies of the same command.
+type repeatProducer struct {
+	prefix  string
+	command []string
+	total   int
+	next    int
+}
+
+// NewRepeatProducer returns a JobProducer that yields n copies of command,
+// equivalent to the queue's original fixed-size job list.
+func NewRepeatProducer(n int, prefix string, command []string) JobProducer {
+	return &repeatProducer{prefix: prefix, command: command, total: n}
+}
+
+func (p *repeatProducer) Next(ctx context.Context) (*bgjob.BackgroundJob, bool, error) {
+	if p.next >= p.total {
+		return nil, false, nil
+	}
+
+	bj := bgjob.New(p.next, p.prefix, p.command)
+	p.next++
+	return bj, true, nil
+}
+
+// readerJob is the on-the-wire representation consumed by
+// NewReaderProducer: one JSON object per line. Only Command is required.
+type readerJob struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+	Env     []string `json:"env"`
+	Cwd     string   `json:"cwd"`
+}
+
+// readerProducer parses one JSON job per line from an io.Reader.
+type readerProducer struct {
+	prefix  string
+	scanner *bufio.Scanner
+	next    int
+}
+
+// NewReaderProducer returns a JobProducer that reads one JSON-encoded job
+// per line from r, in the form {"name":..., "command":[...], "env":[...],
+// "cwd":...} - suitable for driving the queue from a file of command
+// lines, a pipe, or any other io.Reader of NDJSON.
+func NewReaderProducer(prefix string, r io.Reader) JobProducer {
+	return &readerProducer{prefix: prefix, scanner: bufio.NewScanner(r)}
+}
+
+func (p *readerProducer) Next(ctx context.Context) (*bgjob.BackgroundJob, bool, error) {
+	for p.scanner.Scan() {
+		line := strings.TrimSpace(p.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var rj readerJob
+		if err := json.Unmarshal([]byte(line), &rj); err != nil {
+			return nil, false, fmt.Errorf("bgqueue: parsing job line %d: %w", p.next+1, err)
+		}
+		if len(rj.Command) == 0 {
+			return nil, false, fmt.Errorf("bgqueue: job line %d has no command", p.next+1)
+		}
+
+		bj := newJobFromCommand(p.next, p.prefix, Command{
+			Name:    rj.Name,
+			Command: rj.Command,
+			Env:     rj.Env,
+			Cwd:     rj.Cwd,
+		})
+		p.next++
+		return bj, true, nil
+	}
+
+	if err := p.scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,171 @@
+package bgqueue
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rtamalin/telemetry-test-tools/pkg/bgqueue/bgjob"
+	"github.com/rtamalin/telemetry-test-tools/pkg/bgqueue/bgstats"
+)
+
+// ResultWriter receives each job's result as it completes, and the
+// queue's final stats once it finishes, so results can be streamed to a
+// file, piped into jq, or fed to a downstream analyzer instead of only
+// being printed via bgjob.Print.
+type ResultWriter interface {
+	WriteResult(bj *bgjob.BackgroundJob) error
+	WriteSummary(stats *bgstats.BgQueueStats) error
+}
+
+// jobResult is the JSON/NDJSON/CSV wire representation of a completed job.
+type jobResult struct {
+	Id         int       `json:"id"`
+	Name       string    `json:"name"`
+	Command    []string  `json:"command"`
+	Started    time.Time `json:"started"`
+	DurationNs int64     `json:"duration_ns"`
+	ExitStatus int       `json:"exit_status"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func newJobResult(bj *bgjob.BackgroundJob) jobResult {
+	jr := jobResult{
+		Id:         bj.Id,
+		Name:       bj.Name,
+		Command:    bj.Command,
+		Started:    bj.Started,
+		DurationNs: bj.Duration.Nanoseconds(),
+		ExitStatus: bj.ExitStatus,
+		Stdout:     bj.Stdout,
+		Stderr:     bj.Stderr,
+	}
+	if bj.Error != nil {
+		jr.Error = bj.Error.Error()
+	}
+	return jr
+}
+
+// summaryResult is the JSON/CSV representation of the queue's final
+// stats, mirroring bgstats.BgQueueStats.
+type summaryResult struct {
+	Total         int     `json:"total"`
+	Completed     int     `json:"completed"`
+	Succeeded     int     `json:"succeeded"`
+	Failed        int     `json:"failed"`
+	Invalid       int     `json:"invalid"`
+	TimedOut      int     `json:"timed_out"`
+	Retried       int     `json:"retried"`
+	RetrySuccess  int     `json:"retry_success"`
+	TotalAttempts int     `json:"total_attempts"`
+	ActiveTimeSec float64 `json:"active_time_s"`
+}
+
+func newSummaryResult(stats *bgstats.BgQueueStats) summaryResult {
+	return summaryResult{
+		Total:         stats.Total,
+		Completed:     stats.Completed,
+		Succeeded:     stats.Succeeded,
+		Failed:        stats.Failed,
+		Invalid:       stats.Invalid,
+		TimedOut:      stats.TimedOut,
+		Retried:       stats.Retried,
+		RetrySuccess:  stats.RetrySuccess,
+		TotalAttempts: stats.TotalAttempts,
+		ActiveTimeSec: stats.ActiveTime.Seconds(),
+	}
+}
+
+// NDJSONResultWriter writes one JSON object per job result, followed by a
+// final JSON summary object, each terminated with a newline.
+type NDJSONResultWriter struct {
+	enc *json.Encoder
+}
+
+// NewNDJSONResultWriter returns a ResultWriter that encodes each result,
+// and the final summary, as one JSON object per line on w.
+func NewNDJSONResultWriter(w io.Writer) *NDJSONResultWriter {
+	return &NDJSONResultWriter{enc: json.NewEncoder(w)}
+}
+
+func (rw *NDJSONResultWriter) WriteResult(bj *bgjob.BackgroundJob) error {
+	return rw.enc.Encode(newJobResult(bj))
+}
+
+func (rw *NDJSONResultWriter) WriteSummary(stats *bgstats.BgQueueStats) error {
+	return rw.enc.Encode(newSummaryResult(stats))
+}
+
+// csvResultHeader names the columns written by CSVResultWriter.
+var csvResultHeader = []string{"id", "name", "command", "started", "duration_ns", "exit_status", "stdout", "stderr", "error"}
+
+// CSVResultWriter writes one CSV row per job result, preceded by a
+// header row, followed by a blank line and a "key,value" block holding
+// the final summary.
+type CSVResultWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+// NewCSVResultWriter returns a ResultWriter that writes CSV rows to w.
+func NewCSVResultWriter(w io.Writer) *CSVResultWriter {
+	return &CSVResultWriter{w: csv.NewWriter(w)}
+}
+
+func (rw *CSVResultWriter) WriteResult(bj *bgjob.BackgroundJob) error {
+	if !rw.wroteHeader {
+		if err := rw.w.Write(csvResultHeader); err != nil {
+			return err
+		}
+		rw.wroteHeader = true
+	}
+
+	jr := newJobResult(bj)
+	row := []string{
+		strconv.Itoa(jr.Id),
+		jr.Name,
+		strings.Join(jr.Command, " "),
+		jr.Started.Format(time.RFC3339Nano),
+		strconv.FormatInt(jr.DurationNs, 10),
+		strconv.Itoa(jr.ExitStatus),
+		jr.Stdout,
+		jr.Stderr,
+		jr.Error,
+	}
+	if err := rw.w.Write(row); err != nil {
+		return err
+	}
+
+	rw.w.Flush()
+	return rw.w.Error()
+}
+
+func (rw *CSVResultWriter) WriteSummary(stats *bgstats.BgQueueStats) error {
+	rows := [][]string{
+		{},
+		{"total", strconv.Itoa(stats.Total)},
+		{"completed", strconv.Itoa(stats.Completed)},
+		{"succeeded", strconv.Itoa(stats.Succeeded)},
+		{"failed", strconv.Itoa(stats.Failed)},
+		{"invalid", strconv.Itoa(stats.Invalid)},
+		{"timed_out", strconv.Itoa(stats.TimedOut)},
+		{"retried", strconv.Itoa(stats.Retried)},
+		{"retry_success", strconv.Itoa(stats.RetrySuccess)},
+		{"total_attempts", strconv.Itoa(stats.TotalAttempts)},
+		{"active_time_s", strconv.FormatFloat(stats.ActiveTime.Seconds(), 'f', -1, 64)},
+	}
+
+	for _, row := range rows {
+		if err := rw.w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	rw.w.Flush()
+	return rw.w.Error()
+}
@@ -0,0 +1,205 @@
+package bgqueue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rtamalin/telemetry-test-tools/pkg/bgqueue/bgjob"
+	"github.com/rtamalin/telemetry-test-tools/pkg/bgqueue/bgstats"
+)
+
+// jobHeap is a container/heap min-heap of pending jobs, ordered so the
+// job that should run next is always at index 0: earliest Schedule
+// first, ties broken by highest Priority, then by lowest Id.
+type jobHeap []*bgjob.BackgroundJob
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if !h[i].Schedule.Equal(h[j].Schedule) {
+		return h[i].Schedule.Before(h[j].Schedule)
+	}
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	return h[i].Id < h[j].Id
+}
+
+func (h jobHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *jobHeap) Push(x any) {
+	*h = append(*h, x.(*bgjob.BackgroundJob))
+}
+
+func (h *jobHeap) Pop() any {
+	old := *h
+	n := len(old)
+	bj := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return bj
+}
+
+// NewScheduled creates a BgQueue to be driven by RunScheduled. Jobs are
+// added via Submit, whose Priority and Schedule fields determine
+// dispatch order, rather than pre-allocated by New or pulled on demand
+// by a JobProducer.
+func NewScheduled(jobSlots int, prefix string) (bq *BgQueue) {
+	bq = new(BgQueue)
+	bq.numSlots = jobSlots
+	bq.prefix = prefix
+
+	bq.progress = Progress
+
+	bq.Stats = bgstats.New(0)
+
+	bq.jobSlots = make(chan bgJobSlot, jobSlots)
+	bq.wake = make(chan struct{}, 1)
+	bq.JobGroup = new(sync.WaitGroup)
+
+	return
+}
+
+// Submit adds bj to the priority/schedule heap for RunScheduled. It is
+// safe to call concurrently, including before RunScheduled has started
+// or while other jobs are still running. It must not be called from a
+// ProgressCallback: RunScheduled holds statsMu while invoking the
+// callback, and Submit locks statsMu too.
+func (bq *BgQueue) Submit(bj *bgjob.BackgroundJob) {
+	bq.heapMu.Lock()
+	heap.Push(&bq.heap, bj)
+	bq.heapMu.Unlock()
+
+	// guarded separately from the heap: also read by RunScheduled's
+	// draining loop (directly, and via any ProgressCallback)
+	bq.statsMu.Lock()
+	bq.Jobs = append(bq.Jobs, bj)
+	bq.Stats.Total++
+	bq.statsMu.Unlock()
+
+	bq.wakeDispatcher()
+}
+
+// Close signals that no further jobs will be Submit-ted, so RunScheduled
+// can return once the heap has drained instead of waiting forever.
+func (bq *BgQueue) Close() {
+	bq.heapMu.Lock()
+	bq.closed = true
+	bq.heapMu.Unlock()
+
+	bq.wakeDispatcher()
+}
+
+func (bq *BgQueue) wakeDispatcher() {
+	select {
+	case bq.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RunScheduled runs the queue's priority/schedule heap to completion: a
+// dispatcher goroutine pops the next eligible job (Schedule <= now)
+// whenever a slot is free, sleeping until the earliest future Schedule
+// when none are ready, until Close has been called and the heap drains,
+// or ctx is cancelled.
+func (bq *BgQueue) RunScheduled(ctx context.Context) {
+	bq.Stats.Start()
+	bq.QueueStart = time.Now()
+
+	bq.jobResults = make(chan *bgjob.BackgroundJob, bq.numSlots)
+
+	go bq.dispatch(ctx)
+
+	for bj := range bq.jobResults {
+		bq.recordResult(bj)
+
+		bq.statsMu.Lock()
+		if bq.progress != nil {
+			bq.progress(bq, bj)
+		}
+		bq.statsMu.Unlock()
+	}
+	bq.finish()
+}
+
+// dispatch is RunScheduled's scheduler loop. It returns once ctx is
+// cancelled, or the heap is empty and Close has been called, and closes
+// jobResults once every dispatched job has completed so RunScheduled's
+// result loop can drain it to the end.
+func (bq *BgQueue) dispatch(ctx context.Context) {
+	defer func() {
+		bq.JobGroup.Wait()
+		close(bq.jobResults)
+	}()
+
+	for {
+		bq.heapMu.Lock()
+		for bq.heap.Len() == 0 && !bq.closed {
+			bq.heapMu.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-bq.wake:
+			}
+			bq.heapMu.Lock()
+		}
+		if bq.heap.Len() == 0 {
+			bq.heapMu.Unlock()
+			return
+		}
+
+		if wait := bq.heap[0].Schedule.Sub(time.Now()); wait > 0 {
+			bq.heapMu.Unlock()
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			case <-bq.wake:
+				timer.Stop()
+			}
+			continue
+		}
+
+		bj := heap.Pop(&bq.heap).(*bgjob.BackgroundJob)
+		bq.heapMu.Unlock()
+
+		select {
+		case bq.jobSlots <- bgJobSlot{}:
+		case <-ctx.Done():
+			return
+		}
+
+		bq.JobGroup.Add(1)
+		go bq.runScheduledJob(ctx, bj)
+	}
+}
+
+// runScheduledJob runs bj to completion, including retries. Unlike
+// runJob, the slot for its first attempt has already been acquired by
+// its caller (dispatch, or RunProducer), so only retries re-acquire one.
+func (bq *BgQueue) runScheduledJob(ctx context.Context, bj *bgjob.BackgroundJob) {
+	defer bq.JobGroup.Done()
+
+	bj.MarkReady()
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			bq.jobSlots <- bgJobSlot{}
+		}
+		err := bq.runAttempt(ctx, bj, attempt)
+		<-bq.jobSlots
+
+		if !bq.retryable(err, bj, attempt) {
+			break
+		}
+
+		time.Sleep(bq.RetryPolicy.backoff(attempt))
+	}
+
+	bq.jobResults <- bj
+}
@@ -0,0 +1,117 @@
+package bgqueue
+
+import (
+	"container/heap"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rtamalin/telemetry-test-tools/pkg/bgqueue/bgjob"
+)
+
+func scheduledJob(id int, schedule time.Time, priority int) *bgjob.BackgroundJob {
+	bj := bgjob.New(id, "job", []string{"true"})
+	bj.Schedule = schedule
+	bj.Priority = priority
+	return bj
+}
+
+func TestJobHeapOrdersBySchedule(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var h jobHeap
+	heap.Init(&h)
+	heap.Push(&h, scheduledJob(1, now.Add(2*time.Second), 0))
+	heap.Push(&h, scheduledJob(2, now.Add(1*time.Second), 0))
+	heap.Push(&h, scheduledJob(3, now, 0))
+
+	var order []int
+	for h.Len() > 0 {
+		order = append(order, heap.Pop(&h).(*bgjob.BackgroundJob).Id)
+	}
+
+	want := []int{3, 2, 1}
+	for i, id := range want {
+		if order[i] != id {
+			t.Fatalf("pop order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestJobHeapBreaksTiesByPriorityDescending(t *testing.T) {
+	same := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var h jobHeap
+	heap.Init(&h)
+	heap.Push(&h, scheduledJob(1, same, 1))
+	heap.Push(&h, scheduledJob(2, same, 5))
+	heap.Push(&h, scheduledJob(3, same, 3))
+
+	var order []int
+	for h.Len() > 0 {
+		order = append(order, heap.Pop(&h).(*bgjob.BackgroundJob).Id)
+	}
+
+	want := []int{2, 3, 1}
+	for i, id := range want {
+		if order[i] != id {
+			t.Fatalf("pop order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestJobHeapBreaksDoubleTiesById(t *testing.T) {
+	same := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var h jobHeap
+	heap.Init(&h)
+	heap.Push(&h, scheduledJob(3, same, 0))
+	heap.Push(&h, scheduledJob(1, same, 0))
+	heap.Push(&h, scheduledJob(2, same, 0))
+
+	var order []int
+	for h.Len() > 0 {
+		order = append(order, heap.Pop(&h).(*bgjob.BackgroundJob).Id)
+	}
+
+	want := []int{1, 2, 3}
+	for i, id := range want {
+		if order[i] != id {
+			t.Fatalf("pop order = %v, want %v", order, want)
+		}
+	}
+}
+
+// TestRunScheduledRespectsScheduleAndPriority exercises dispatch's
+// eligibility logic end to end: a future-scheduled high-priority job must
+// still wait for its Schedule, letting an eligible lower-priority job run
+// first.
+func TestRunScheduledRespectsScheduleAndPriority(t *testing.T) {
+	bq := NewScheduled(1, "job")
+
+	now := time.Now()
+	bq.Submit(scheduledJob(1, now.Add(100*time.Millisecond), 10))
+	bq.Submit(scheduledJob(2, now, 0))
+	bq.Close()
+
+	var order []int
+	bq.SetProgress(func(_ *BgQueue, bj *bgjob.BackgroundJob) {
+		order = append(order, bj.Id)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		bq.RunScheduled(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunScheduled did not complete in time")
+	}
+
+	if len(order) != 2 || order[0] != 2 || order[1] != 1 {
+		t.Fatalf("completion order = %v, want [2 1]", order)
+	}
+}